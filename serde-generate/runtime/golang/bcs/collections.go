@@ -0,0 +1,72 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs
+
+import "github.com/zefchain/serde-reflection/serde-generate/runtime/golang/serde"
+
+// SerializeMap writes a BCS map of n entries. emit is called once per entry,
+// in index order, and must serialize exactly one key followed by its value.
+// SerializeMap records the buffer offset before each call and hands the
+// resulting offsets to SortMapEntries afterwards, so callers no longer need
+// to do that bookkeeping by hand to get canonical (lexicographically
+// key-sorted) output.
+func (s *Serializer) SerializeMap(n int, emit func(i int) error) error {
+	if err := s.SerializeLen(uint64(n)); err != nil {
+		return err
+	}
+	offsets := make([]uint64, 0, n)
+	for i := 0; i < n; i++ {
+		offsets = append(offsets, s.GetBufferOffset())
+		if err := emit(i); err != nil {
+			return err
+		}
+	}
+	s.SortMapEntries(offsets)
+	return nil
+}
+
+// SerializeSet writes a BCS set of n entries, canonically ordered the same
+// way SerializeMap orders map entries. emit is called once per entry to
+// serialize its value.
+func (s *Serializer) SerializeSet(n int, emit func(i int) error) error {
+	return s.SerializeMap(n, emit)
+}
+
+// DeserializeMap reads a BCS map: it decodes the entry count, then for each
+// entry calls readKey followed by readValue, and checks that successive keys
+// came out in strictly increasing order via CheckThatKeySlicesAreIncreasing,
+// the same canonical-form guarantee generated code enforces by hand today.
+// Comparing keys alone (rather than whole key/value entries) is what catches
+// a duplicate key whose entries would otherwise still look increasing once
+// the differing value bytes are folded in.
+func (d *Deserializer) DeserializeMap(readKey, readValue func(i int) error) error {
+	n, err := d.DeserializeLen()
+	if err != nil {
+		return err
+	}
+	var previousKey serde.Slice
+	for i := uint64(0); i < n; i++ {
+		keyStart := d.GetBufferOffset()
+		if err := readKey(int(i)); err != nil {
+			return err
+		}
+		key := serde.Slice{Start: keyStart, End: d.GetBufferOffset()}
+		if i > 0 {
+			if err := d.CheckThatKeySlicesAreIncreasing(previousKey, key); err != nil {
+				return err
+			}
+		}
+		previousKey = key
+		if err := readValue(int(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeserializeSet reads a BCS set with the same ordering guarantee as
+// DeserializeMap; a set has no separate value to read per entry.
+func (d *Deserializer) DeserializeSet(readElement func(i int) error) error {
+	return d.DeserializeMap(readElement, func(i int) error { return nil })
+}
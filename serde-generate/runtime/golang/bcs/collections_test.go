@@ -0,0 +1,103 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs_test
+
+import (
+	"testing"
+
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/bcs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeDeserializeMap(t *testing.T) {
+	entries := []struct {
+		key   uint32
+		value string
+	}{
+		{key: 2, value: "b"},
+		{key: 0, value: "a"},
+		{key: 1, value: "c"},
+	}
+
+	s := bcs.NewSerializer()
+	err := s.SerializeMap(len(entries), func(i int) error {
+		if err := s.SerializeU32(entries[i].key); err != nil {
+			return err
+		}
+		return s.SerializeStr(entries[i].value)
+	})
+	require.NoError(t, err)
+
+	d := bcs.NewDeserializer(s.GetBytes())
+	var keys []uint32
+	var values []string
+	err = d.DeserializeMap(
+		func(i int) error {
+			key, err := d.DeserializeU32()
+			keys = append(keys, key)
+			return err
+		},
+		func(i int) error {
+			value, err := d.DeserializeStr()
+			values = append(values, value)
+			return err
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{0, 1, 2}, keys, "entries come back in canonical key order")
+	assert.Equal(t, []string{"a", "c", "b"}, values)
+}
+
+func TestDeserializeMapRejectsDuplicateKeyDifferingOnlyByValue(t *testing.T) {
+	// Two entries sharing key 1, differing only in value ("a" < "b"): if the
+	// comparison folded value bytes into the key comparison, the entries
+	// would look increasing ("1a" < "1b"); comparing key bytes alone must
+	// reject the duplicate key.
+	s := bcs.NewSerializer()
+	require.NoError(t, s.SerializeU8(1))
+	require.NoError(t, s.SerializeStr("a"))
+	require.NoError(t, s.SerializeU8(1))
+	require.NoError(t, s.SerializeStr("b"))
+	entryBytes := s.GetBytes()
+
+	raw := append([]byte{2}, entryBytes...) // map of 2 entries
+	d := bcs.NewDeserializer(raw)
+	err := d.DeserializeMap(
+		func(i int) error { _, err := d.DeserializeU8(); return err },
+		func(i int) error { _, err := d.DeserializeStr(); return err },
+	)
+	require.Error(t, err)
+}
+
+func TestSerializeDeserializeSet(t *testing.T) {
+	values := []uint32{3, 1, 2}
+
+	s := bcs.NewSerializer()
+	err := s.SerializeSet(len(values), func(i int) error {
+		return s.SerializeU32(values[i])
+	})
+	require.NoError(t, err)
+
+	d := bcs.NewDeserializer(s.GetBytes())
+	var got []uint32
+	err = d.DeserializeSet(func(i int) error {
+		v, err := d.DeserializeU32()
+		got = append(got, v)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{1, 2, 3}, got)
+}
+
+func TestSerializeMapPropagatesEmitError(t *testing.T) {
+	s := bcs.NewSerializer()
+	err := s.SerializeMap(2, func(i int) error {
+		if i == 1 {
+			return assert.AnError
+		}
+		return s.SerializeU8(0)
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,205 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs_test
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/bcs"
+	"github.com/stretchr/testify/require"
+)
+
+// FuzzDeserializeBytesRoundTrip checks BCS's canonical-form guarantee for
+// DeserializeBytes: whenever it accepts a prefix of the input, re-encoding
+// the decoded value must reproduce that prefix byte-for-byte.
+func FuzzDeserializeBytesRoundTrip(f *testing.F) {
+	f.Add([]byte{3, 1, 2, 38})
+	f.Add([]byte{0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeBytes()
+		if err != nil {
+			return
+		}
+		consumed := d.GetBufferOffset()
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeBytes(value))
+		require.Equal(t, data[:consumed], s.GetBytes())
+	})
+}
+
+// FuzzDeserializeStrRoundTrip additionally checks that every decoded string
+// is valid UTF-8, since DeserializeStr is supposed to reject anything else.
+func FuzzDeserializeStrRoundTrip(f *testing.F) {
+	f.Add([]byte{12, 104, 101, 108, 108, 111, 32, 119, 111, 114, 108, 100, 33})
+	f.Add([]byte{0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeStr()
+		if err != nil {
+			return
+		}
+		require.True(t, utf8.ValidString(value))
+		consumed := d.GetBufferOffset()
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeStr(value))
+		require.Equal(t, data[:consumed], s.GetBytes())
+	})
+}
+
+// FuzzDeserializeBoolRoundTrip checks that DeserializeBool only ever accepts
+// the bytes 0 and 1, and that both round-trip exactly.
+func FuzzDeserializeBoolRoundTrip(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{1})
+	f.Add([]byte{2})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeBool()
+		if err != nil {
+			return
+		}
+		require.Equal(t, uint64(1), d.GetBufferOffset()) // a bool is exactly one byte: 0 or 1
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeBool(value))
+		require.Equal(t, data[:1], s.GetBytes())
+	})
+}
+
+// FuzzDeserializeVariantIndexRoundTrip exercises the ULEB128-encoded variant
+// index used by enum discriminants.
+func FuzzDeserializeVariantIndexRoundTrip(f *testing.F) {
+	f.Add([]byte{143, 74})
+	f.Add([]byte{255, 255, 255, 255, 255, 255, 255, 255})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeVariantIndex()
+		if err != nil {
+			return
+		}
+		consumed := d.GetBufferOffset()
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeVariantIndex(value))
+		require.Equal(t, data[:consumed], s.GetBytes())
+	})
+}
+
+// FuzzDeserializeU128RoundTrip and FuzzDeserializeI128RoundTrip cover the two
+// 128-bit integer types, which are assembled from a pair of 64-bit reads.
+func FuzzDeserializeU128RoundTrip(f *testing.F) {
+	f.Add(make([]byte, 16))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeU128()
+		if err != nil {
+			return
+		}
+		consumed := d.GetBufferOffset()
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeU128(value))
+		require.Equal(t, data[:consumed], s.GetBytes())
+	})
+}
+
+func FuzzDeserializeI128RoundTrip(f *testing.F) {
+	f.Add(make([]byte, 16))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeI128()
+		if err != nil {
+			return
+		}
+		consumed := d.GetBufferOffset()
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeI128(value))
+		require.Equal(t, data[:consumed], s.GetBytes())
+	})
+}
+
+// FuzzDeserializeLen proves the ULEB128 length decoder never panics and
+// rejects every encoding that is non-minimal or whose value is >= 2^32.
+func FuzzDeserializeLen(f *testing.F) {
+	f.Add([]byte{255, 255, 255, 255, 255, 255, 255, 255})
+	f.Add([]byte{0x80, 0x00}) // non-minimal: an unnecessary continuation byte
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := bcs.NewDeserializer(data)
+		value, err := d.DeserializeLen()
+		if err != nil {
+			return
+		}
+		require.Less(t, value, uint64(1)<<32)
+
+		// A minimal ULEB128 encoding never re-encodes to something shorter.
+		consumed := d.GetBufferOffset()
+		s := bcs.NewSerializer()
+		require.NoError(t, s.SerializeLen(value))
+		require.Equal(t, data[:consumed], s.GetBytes())
+	})
+}
+
+// FuzzSortMapEntries checks bcs.Serializer.SortMapEntries against a
+// from-scratch reference implementation of the same sliding-window,
+// stable-sort algorithm, so a future refactor can't silently change how
+// canonical map ordering is computed.
+func FuzzSortMapEntries(f *testing.F) {
+	f.Add([]byte{255, 1, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0}, []byte{1, 2, 4, 7, 8, 9})
+	f.Fuzz(func(t *testing.T, data []byte, offsetSeeds []byte) {
+		if len(data) == 0 {
+			return
+		}
+		offsets := deriveOffsets(data, offsetSeeds)
+
+		s := bcs.NewSerializer()
+		for _, b := range data {
+			require.NoError(t, s.SerializeU8(b))
+		}
+		s.SortMapEntries(offsets)
+
+		require.Equal(t, referenceSortMapEntries(data, offsets), s.GetBytes())
+	})
+}
+
+// deriveOffsets turns arbitrary fuzzer bytes into a strictly increasing list
+// of offsets into data, which is what SortMapEntries expects.
+func deriveOffsets(data, seeds []byte) []uint64 {
+	unique := map[uint64]bool{}
+	for _, seed := range seeds {
+		unique[uint64(seed)%uint64(len(data))] = true
+	}
+	offsets := make([]uint64, 0, len(unique))
+	for offset := range unique {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+// referenceSortMapEntries is an independent reimplementation of the
+// sliding-window, stable-sort algorithm SortMapEntries is documented to use:
+// consecutive offsets delimit entries (the last one running to the end of
+// the buffer), and those entries are stably sorted by their raw bytes.
+func referenceSortMapEntries(data []byte, offsets []uint64) []byte {
+	out := append([]byte(nil), data...)
+	if len(offsets) <= 1 {
+		return out
+	}
+	entries := make([][]byte, 0, len(offsets))
+	last := offsets[0]
+	for _, offset := range offsets[1:] {
+		entries = append(entries, data[last:offset])
+		last = offset
+	}
+	entries = append(entries, data[last:])
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+	buf := out[:offsets[0]]
+	for _, entry := range entries {
+		buf = append(buf, entry...)
+	}
+	return buf
+}
@@ -0,0 +1,474 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/serde"
+)
+
+// EnumVariant is implemented by the concrete Go types used for an
+// interface{} field tagged `bcs:"variant_index"`, mirroring how
+// serde-generate represents a Rust/Serde enum as one struct per variant.
+type EnumVariant interface {
+	// BCSVariantIndex reports this value's position among the enum's
+	// variants, as assigned by the original schema.
+	BCSVariantIndex() uint32
+}
+
+type variantKey struct {
+	owner reflect.Type
+	field string
+}
+
+var variantTypes = map[variantKey]map[uint32]reflect.Type{}
+
+// RegisterVariant tells Unmarshal which concrete type to build for a given
+// variant index when decoding into the named interface{} field of owner.
+// Marshal needs no such registration: it reads the index straight off the
+// field's EnumVariant implementation.
+func RegisterVariant(owner interface{}, field string, variant EnumVariant) {
+	key := variantKey{owner: underlyingType(owner), field: field}
+	variants, ok := variantTypes[key]
+	if !ok {
+		variants = map[uint32]reflect.Type{}
+		variantTypes[key] = variants
+	}
+	variants[variant.BCSVariantIndex()] = reflect.TypeOf(variant)
+}
+
+func underlyingType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// Marshal serializes v into its canonical BCS encoding using reflection,
+// without requiring serde-generate to have produced per-type (de)serialization
+// code for it first. It is meant for ad hoc structs that have no generated
+// counterpart; performance-critical, high-volume paths should still prefer
+// serde-generate output. Nested pointers, slices, arrays, and maps are bound
+// by the same MaxContainerDepth as generated code, so a self-referential or
+// adversarially deep value is rejected instead of overflowing the stack.
+//
+// Supported Go types are the usual BCS primitives (bool, string, u8-u64 and
+// i8-i64 as Go's matching [u]int sizes, serde.Uint128/serde.Int128), slices
+// (length-prefixed sequences, []byte included), fixed-size arrays (just the
+// elements, with no length prefix, matching canonical BCS for [N]T), maps
+// (length-prefixed and sorted into canonical key order), pointers (encoded
+// as a BCS option), and structs (fields encoded in declaration order). An
+// untagged interface{} field cannot be (de)serialized; use
+// `bcs:"variant_index"` (see below) for enum-like fields instead. A
+// `bcs:"..."` struct tag overrides a field's default encoding:
+//
+//	bcs:"-"             skip the field entirely
+//	bcs:"variant_index" encode an interface{} field as an enum: its
+//	                    EnumVariant index followed by the concrete value
+func Marshal(v interface{}) ([]byte, error) {
+	s := NewSerializer()
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("bcs: Marshal called with a nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if err := marshalValue(s, rv, ""); err != nil {
+		return nil, err
+	}
+	return s.GetBytes(), nil
+}
+
+// Unmarshal decodes data, in canonical BCS encoding, into v, which must be a
+// non-nil pointer. See Marshal for the supported types and struct tags.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("bcs: Unmarshal requires a non-nil pointer, got %T", v)
+	}
+	return unmarshalValue(NewDeserializer(data), rv.Elem())
+}
+
+func fieldTag(f reflect.StructField) (tag string, skip bool) {
+	raw, ok := f.Tag.Lookup("bcs")
+	if !ok {
+		return "", false
+	}
+	if raw == "-" {
+		return "", true
+	}
+	return raw, false
+}
+
+func marshalValue(s *Serializer, v reflect.Value, tag string) error {
+	if err := s.IncreaseContainerDepth(); err != nil {
+		return err
+	}
+	defer s.DecreaseContainerDepth()
+
+	if tag == "variant_index" {
+		return marshalVariant(s, v)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return s.SerializeOptionTag(false)
+		}
+		if err := s.SerializeOptionTag(true); err != nil {
+			return err
+		}
+		return marshalValue(s, v.Elem(), "")
+	case reflect.Bool:
+		return s.SerializeBool(v.Bool())
+	case reflect.String:
+		return s.SerializeStr(v.String())
+	case reflect.Uint8:
+		return s.SerializeU8(uint8(v.Uint()))
+	case reflect.Uint16:
+		return s.SerializeU16(uint16(v.Uint()))
+	case reflect.Uint32:
+		return s.SerializeU32(uint32(v.Uint()))
+	case reflect.Uint64:
+		return s.SerializeU64(v.Uint())
+	case reflect.Int8:
+		return s.SerializeI8(int8(v.Int()))
+	case reflect.Int16:
+		return s.SerializeI16(int16(v.Int()))
+	case reflect.Int32:
+		return s.SerializeI32(int32(v.Int()))
+	case reflect.Int64:
+		return s.SerializeI64(v.Int())
+	case reflect.Struct:
+		switch typed := v.Interface().(type) {
+		case serde.Uint128:
+			return s.SerializeU128(typed)
+		case serde.Int128:
+			return s.SerializeI128(typed)
+		}
+		return marshalStruct(s, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return s.SerializeBytes(v.Bytes())
+		}
+		return marshalSequence(s, v)
+	case reflect.Array:
+		return marshalArray(s, v)
+	case reflect.Map:
+		return marshalMap(s, v)
+	case reflect.Interface:
+		return fmt.Errorf("bcs: untagged interface{} field of type %s: tag it `bcs:\"variant_index\"` and implement bcs.EnumVariant", v.Type())
+	default:
+		return fmt.Errorf("bcs: unsupported type %s", v.Type())
+	}
+}
+
+func marshalStruct(s *Serializer, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag, skip := fieldTag(field)
+		if skip {
+			continue
+		}
+		if err := marshalValue(s, v.Field(i), tag); err != nil {
+			return fmt.Errorf("bcs: field %s.%s: %w", t.Name(), field.Name, err)
+		}
+	}
+	return nil
+}
+
+// marshalSequence serializes a slice as a BCS sequence: a ULEB128 length
+// prefix followed by each element.
+func marshalSequence(s *Serializer, v reflect.Value) error {
+	n := v.Len()
+	if err := s.SerializeLen(uint64(n)); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := marshalValue(s, v.Index(i), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalArray serializes a fixed-size [N]T array as just its elements, with
+// no length prefix: unlike a slice, its length is already part of the static
+// type, so BCS does not encode it.
+func marshalArray(s *Serializer, v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		if err := marshalValue(s, v.Index(i), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalMap serializes a map using the same recorded-offsets-plus-sort
+// approach SerializeMap exposes directly, since reflect.Value.MapKeys()
+// returns keys in randomized order and BCS requires canonical (sorted) ones.
+func marshalMap(s *Serializer, v reflect.Value) error {
+	keys := v.MapKeys()
+	return s.SerializeMap(len(keys), func(i int) error {
+		key := keys[i]
+		if err := marshalValue(s, key, ""); err != nil {
+			return err
+		}
+		return marshalValue(s, v.MapIndex(key), "")
+	})
+}
+
+func marshalVariant(s *Serializer, v reflect.Value) error {
+	if v.Kind() != reflect.Interface || v.IsNil() {
+		return fmt.Errorf("bcs: variant_index field must hold a non-nil interface value")
+	}
+	variant, ok := v.Interface().(EnumVariant)
+	if !ok {
+		return fmt.Errorf("bcs: %s does not implement bcs.EnumVariant", v.Elem().Type())
+	}
+	if err := s.SerializeVariantIndex(variant.BCSVariantIndex()); err != nil {
+		return err
+	}
+	return marshalValue(s, reflect.ValueOf(variant), "")
+}
+
+func unmarshalValue(d *Deserializer, v reflect.Value) error {
+	if err := d.IncreaseContainerDepth(); err != nil {
+		return err
+	}
+	defer d.DecreaseContainerDepth()
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		present, err := d.DeserializeOptionTag()
+		if err != nil {
+			return err
+		}
+		if !present {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := unmarshalValue(d, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Bool:
+		b, err := d.DeserializeBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.String:
+		str, err := d.DeserializeStr()
+		if err != nil {
+			return err
+		}
+		v.SetString(str)
+		return nil
+	case reflect.Uint8:
+		x, err := d.DeserializeU8()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(x))
+		return nil
+	case reflect.Uint16:
+		x, err := d.DeserializeU16()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(x))
+		return nil
+	case reflect.Uint32:
+		x, err := d.DeserializeU32()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(x))
+		return nil
+	case reflect.Uint64:
+		x, err := d.DeserializeU64()
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+		return nil
+	case reflect.Int8:
+		x, err := d.DeserializeI8()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(x))
+		return nil
+	case reflect.Int16:
+		x, err := d.DeserializeI16()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(x))
+		return nil
+	case reflect.Int32:
+		x, err := d.DeserializeI32()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(x))
+		return nil
+	case reflect.Int64:
+		x, err := d.DeserializeI64()
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+		return nil
+	case reflect.Struct:
+		switch v.Type() {
+		case reflect.TypeOf(serde.Uint128{}):
+			u, err := d.DeserializeU128()
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(u))
+			return nil
+		case reflect.TypeOf(serde.Int128{}):
+			i, err := d.DeserializeI128()
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(i))
+			return nil
+		}
+		return unmarshalStruct(d, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.DeserializeBytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		return unmarshalSlice(d, v)
+	case reflect.Array:
+		return unmarshalArray(d, v)
+	case reflect.Map:
+		return unmarshalMap(d, v)
+	default:
+		return fmt.Errorf("bcs: unsupported type %s", v.Type())
+	}
+}
+
+func unmarshalStruct(d *Deserializer, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag, skip := fieldTag(field)
+		if skip {
+			continue
+		}
+		if tag == "variant_index" {
+			if err := unmarshalVariant(d, v.Field(i), t, field.Name); err != nil {
+				return fmt.Errorf("bcs: field %s.%s: %w", t.Name(), field.Name, err)
+			}
+			continue
+		}
+		if err := unmarshalValue(d, v.Field(i)); err != nil {
+			return fmt.Errorf("bcs: field %s.%s: %w", t.Name(), field.Name, err)
+		}
+	}
+	return nil
+}
+
+func unmarshalSlice(d *Deserializer, v reflect.Value) error {
+	n, err := d.DeserializeLen()
+	if err != nil {
+		return err
+	}
+	out := reflect.MakeSlice(v.Type(), int(n), int(n))
+	for i := 0; i < int(n); i++ {
+		if err := unmarshalValue(d, out.Index(i)); err != nil {
+			return err
+		}
+	}
+	v.Set(out)
+	return nil
+}
+
+// unmarshalArray reads a fixed-size [N]T array as just its elements, with no
+// length prefix to consume: see marshalArray.
+func unmarshalArray(d *Deserializer, v reflect.Value) error {
+	for i := 0; i < v.Len(); i++ {
+		if err := unmarshalValue(d, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalMap delegates to Deserializer.DeserializeMap so that, like every
+// other canonical-form check in this package, a map whose entries are not in
+// strictly increasing key order is rejected instead of silently accepted.
+func unmarshalMap(d *Deserializer, v reflect.Value) error {
+	keyType := v.Type().Key()
+	valType := v.Type().Elem()
+	out := reflect.MakeMap(v.Type())
+	var key reflect.Value
+	err := d.DeserializeMap(
+		func(i int) error {
+			key = reflect.New(keyType).Elem()
+			return unmarshalValue(d, key)
+		},
+		func(i int) error {
+			val := reflect.New(valType).Elem()
+			if err := unmarshalValue(d, val); err != nil {
+				return err
+			}
+			out.SetMapIndex(key, val)
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	v.Set(out)
+	return nil
+}
+
+func unmarshalVariant(d *Deserializer, v reflect.Value, owner reflect.Type, field string) error {
+	if v.Kind() != reflect.Interface {
+		return fmt.Errorf("bcs: variant_index field must be an interface{}, got %s", v.Type())
+	}
+	idx, err := d.DeserializeVariantIndex()
+	if err != nil {
+		return err
+	}
+	variants := variantTypes[variantKey{owner: owner, field: field}]
+	variantType, ok := variants[idx]
+	if !ok {
+		return fmt.Errorf("bcs: no variant registered for %s.%s index %d (call bcs.RegisterVariant)", owner.Name(), field, idx)
+	}
+	if !variantType.AssignableTo(v.Type()) {
+		return fmt.Errorf("bcs: variant %s registered for %s.%s is not assignable to %s", variantType, owner.Name(), field, v.Type())
+	}
+	instance := reflect.New(variantType).Elem()
+	if err := unmarshalValue(d, instance); err != nil {
+		return err
+	}
+	v.Set(instance)
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs_test
+
+import (
+	"testing"
+
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/bcs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type marshalPoint struct {
+	X int32
+	Y int32
+}
+
+type marshalPerson struct {
+	Name    string
+	Age     uint8
+	Tags    []string
+	Parent  *marshalPerson
+	Friends map[string]uint8
+	Ignored string `bcs:"-"`
+}
+
+func TestMarshalUnmarshalStruct(t *testing.T) {
+	target := marshalPerson{
+		Name: "alice",
+		Age:  30,
+		Tags: []string{"a", "b"},
+		Parent: &marshalPerson{
+			Name: "bob",
+			Age:  60,
+		},
+		Friends: map[string]uint8{"z": 1, "a": 2},
+		Ignored: "dropped",
+	}
+
+	data, err := bcs.Marshal(&target)
+	require.NoError(t, err)
+
+	var got marshalPerson
+	require.NoError(t, bcs.Unmarshal(data, &got))
+
+	target.Ignored = ""
+	// BCS has no nil: an empty sequence or map decodes as empty, not nil.
+	target.Parent.Tags = []string{}
+	target.Parent.Friends = map[string]uint8{}
+	assert.Equal(t, target, got)
+}
+
+func TestMarshalUnmarshalArray(t *testing.T) {
+	target := [3]marshalPoint{{1, 2}, {3, 4}, {5, 6}}
+
+	data, err := bcs.Marshal(&target)
+	require.NoError(t, err)
+	// a fixed-size array has no length prefix: 3 elements * 2 int32 fields * 4 bytes
+	assert.Equal(t, 24, len(data))
+
+	var got [3]marshalPoint
+	require.NoError(t, bcs.Unmarshal(data, &got))
+	assert.Equal(t, target, got)
+}
+
+func TestMarshalNilPointer(t *testing.T) {
+	var p *marshalPoint
+	_, err := bcs.Marshal(p)
+	require.Error(t, err)
+}
+
+func TestUnmarshalRequiresNonNilPointer(t *testing.T) {
+	var x int
+	require.Error(t, bcs.Unmarshal([]byte{0}, x))
+	var p *int
+	require.Error(t, bcs.Unmarshal([]byte{0}, p))
+}
+
+func TestMarshalUntaggedInterfaceFieldRejected(t *testing.T) {
+	type withInterface struct {
+		Value interface{}
+	}
+	_, err := bcs.Marshal(&withInterface{Value: 1})
+	require.Error(t, err)
+}
+
+type marshalAnimal interface {
+	bcs.EnumVariant
+}
+
+type marshalDog struct {
+	Name string
+}
+
+func (marshalDog) BCSVariantIndex() uint32 { return 0 }
+
+type marshalCat struct {
+	Lives uint8
+}
+
+func (marshalCat) BCSVariantIndex() uint32 { return 1 }
+
+type marshalOwner struct {
+	Pet marshalAnimal `bcs:"variant_index"`
+}
+
+func TestMarshalUnmarshalVariant(t *testing.T) {
+	bcs.RegisterVariant(marshalOwner{}, "Pet", marshalDog{})
+	bcs.RegisterVariant(marshalOwner{}, "Pet", marshalCat{})
+
+	target := marshalOwner{Pet: marshalCat{Lives: 9}}
+	data, err := bcs.Marshal(&target)
+	require.NoError(t, err)
+
+	var got marshalOwner
+	require.NoError(t, bcs.Unmarshal(data, &got))
+	assert.Equal(t, target, got)
+}
+
+func TestUnmarshalVariantUnregisteredIndex(t *testing.T) {
+	type owner struct {
+		Pet marshalAnimal `bcs:"variant_index"`
+	}
+	data := []byte{42} // variant index 42, never registered for owner.Pet
+	var got owner
+	require.Error(t, bcs.Unmarshal(data, &got))
+}
+
+// a self-referential type fed input with no terminating case: Unmarshal must reject it
+// via the container-depth bound instead of recursing until the stack
+// overflows.
+type marshalNode struct {
+	Next *marshalNode
+}
+
+func TestUnmarshalRejectsExcessiveDepth(t *testing.T) {
+	data := make([]byte, 2_000_000)
+	for i := range data {
+		data[i] = 1 // "option present" for every nested *Next
+	}
+	var got marshalNode
+	err := bcs.Unmarshal(data, &got)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "maximum container depth")
+}
@@ -0,0 +1,545 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/serde"
+)
+
+// StreamSerializer is a serde.Serializer that writes each value straight to
+// an io.Writer as it is serialized, instead of accumulating the whole output
+// in memory the way Serializer does. Use it to encode directly into a
+// bufio.Writer or net.Conn without holding a second copy of a large payload.
+//
+// SortMapEntries and GetBytes need bytes that, by default, have already been
+// forwarded to w and cannot be un-sent: callers that need canonical map
+// ordering on the write side must opt in with WithBuffering.
+type StreamSerializer struct {
+	w          io.Writer
+	offset     uint64
+	buffer     []byte
+	bufferBase uint64
+	depth      int
+}
+
+// NewStreamSerializer returns a StreamSerializer that writes to w.
+func NewStreamSerializer(w io.Writer) *StreamSerializer {
+	return &StreamSerializer{w: w}
+}
+
+// WithBuffering switches s to accumulate every subsequent write in memory
+// instead of forwarding it to w immediately, the same trade-off
+// StreamDeserializer.WithLookahead makes on the read side. Call Flush to send
+// the buffered bytes on to w once done; GetBytes and SortMapEntries are
+// no-ops without it, since there would be nothing left to rewrite.
+func (s *StreamSerializer) WithBuffering() *StreamSerializer {
+	s.buffer = []byte{}
+	s.bufferBase = s.offset
+	return s
+}
+
+// Flush writes any bytes accumulated via WithBuffering to w and resets the
+// buffer, so encoding can go back to writing straight through.
+func (s *StreamSerializer) Flush() error {
+	if s.buffer == nil {
+		return nil
+	}
+	buffered := s.buffer
+	s.buffer = nil
+	_, err := s.w.Write(buffered)
+	return err
+}
+
+func (s *StreamSerializer) write(p []byte) error {
+	if s.buffer != nil {
+		s.buffer = append(s.buffer, p...)
+		s.offset += uint64(len(p))
+		return nil
+	}
+	n, err := s.w.Write(p)
+	s.offset += uint64(n)
+	return err
+}
+
+// GetBufferOffset returns the number of bytes written (or, once WithBuffering
+// is active, buffered) so far.
+func (s *StreamSerializer) GetBufferOffset() uint64 {
+	return s.offset
+}
+
+// GetBytes returns the bytes accumulated since WithBuffering was called. A
+// StreamSerializer that was never switched into buffering mode has already
+// forwarded every byte to w and returns nil.
+func (s *StreamSerializer) GetBytes() []byte {
+	return s.buffer
+}
+
+func (s *StreamSerializer) SerializeU8(value uint8) error {
+	return s.write([]byte{value})
+}
+
+func (s *StreamSerializer) SerializeU16(value uint16) error {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], value)
+	return s.write(b[:])
+}
+
+func (s *StreamSerializer) SerializeU32(value uint32) error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], value)
+	return s.write(b[:])
+}
+
+func (s *StreamSerializer) SerializeU64(value uint64) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], value)
+	return s.write(b[:])
+}
+
+func (s *StreamSerializer) SerializeU128(value serde.Uint128) error {
+	if err := s.SerializeU64(value.Low); err != nil {
+		return err
+	}
+	return s.SerializeU64(value.High)
+}
+
+func (s *StreamSerializer) SerializeI8(value int8) error {
+	return s.SerializeU8(uint8(value))
+}
+
+func (s *StreamSerializer) SerializeI16(value int16) error {
+	return s.SerializeU16(uint16(value))
+}
+
+func (s *StreamSerializer) SerializeI32(value int32) error {
+	return s.SerializeU32(uint32(value))
+}
+
+func (s *StreamSerializer) SerializeI64(value int64) error {
+	return s.SerializeU64(uint64(value))
+}
+
+func (s *StreamSerializer) SerializeI128(value serde.Int128) error {
+	if err := s.SerializeU64(value.Low); err != nil {
+		return err
+	}
+	return s.SerializeI64(value.High)
+}
+
+func (s *StreamSerializer) SerializeBool(value bool) error {
+	if value {
+		return s.SerializeU8(1)
+	}
+	return s.SerializeU8(0)
+}
+
+func (s *StreamSerializer) SerializeUnit(value struct{}) error {
+	return nil
+}
+
+// SerializeChar is unimplemented: BCS has no canonical encoding for a bare
+// Unicode scalar value, the same restriction Serializer enforces.
+func (s *StreamSerializer) SerializeChar(value rune) error {
+	return errors.New("bcs: the `char` type is not supported")
+}
+
+// SerializeF32 is unimplemented: BCS deliberately has no canonical encoding
+// for floating-point values, the same restriction Serializer enforces.
+func (s *StreamSerializer) SerializeF32(value float32) error {
+	return errors.New("bcs: the `f32` type is not supported")
+}
+
+// SerializeF64 is unimplemented: see SerializeF32.
+func (s *StreamSerializer) SerializeF64(value float64) error {
+	return errors.New("bcs: the `f64` type is not supported")
+}
+
+func (s *StreamSerializer) SerializeStr(value string) error {
+	return s.SerializeBytes([]byte(value))
+}
+
+func (s *StreamSerializer) SerializeBytes(value []byte) error {
+	if err := s.SerializeLen(uint64(len(value))); err != nil {
+		return err
+	}
+	return s.write(value)
+}
+
+func (s *StreamSerializer) SerializeLen(value uint64) error {
+	if value > MaxSequenceLength {
+		return errors.New("length is too large")
+	}
+	return s.write(appendULEB128(uint32(value)))
+}
+
+func (s *StreamSerializer) SerializeVariantIndex(value uint32) error {
+	return s.write(appendULEB128(value))
+}
+
+func (s *StreamSerializer) SerializeOptionTag(value bool) error {
+	return s.SerializeBool(value)
+}
+
+// SortMapEntries reorders the buffered region between consecutive offsets
+// into canonical (lexicographically sorted) order, using the same
+// sliding-window, stable-sort algorithm as Serializer.SortMapEntries. Without
+// WithBuffering, the bytes it would need to reorder have already been
+// forwarded to w and it has nothing left to do.
+func (s *StreamSerializer) SortMapEntries(offsets []uint64) {
+	if s.buffer == nil || len(offsets) <= 1 {
+		return
+	}
+	local := make([]uint64, len(offsets))
+	for i, offset := range offsets {
+		local[i] = offset - s.bufferBase
+	}
+	sortBufferEntries(s.buffer, local)
+}
+
+// sortBufferEntries implements the sliding-window, stable-sort algorithm
+// Serializer.SortMapEntries is documented to use: consecutive offsets delimit
+// entries, the last one running to the end of buf, and those entries are
+// stably sorted by their raw bytes back into buf starting at offsets[0].
+func sortBufferEntries(buf []byte, offsets []uint64) {
+	entries := make([][]byte, 0, len(offsets))
+	last := offsets[0]
+	for _, offset := range offsets[1:] {
+		entries = append(entries, append([]byte(nil), buf[last:offset]...))
+		last = offset
+	}
+	entries = append(entries, append([]byte(nil), buf[last:]...))
+	sort.SliceStable(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+	pos := offsets[0]
+	for _, entry := range entries {
+		copy(buf[pos:], entry)
+		pos += uint64(len(entry))
+	}
+}
+
+// IncreaseContainerDepth bounds recursive (de)serialization the same way
+// Serializer/Deserializer do, rejecting deeply nested or self-referential
+// input instead of recursing until the stack overflows.
+func (s *StreamSerializer) IncreaseContainerDepth() error {
+	if s.depth >= MaxContainerDepth {
+		return errors.New("bcs: exceeded maximum container depth")
+	}
+	s.depth++
+	return nil
+}
+
+// DecreaseContainerDepth undoes one IncreaseContainerDepth call.
+func (s *StreamSerializer) DecreaseContainerDepth() {
+	s.depth--
+}
+
+// StreamDeserializer is a serde.Deserializer that pulls each value straight
+// from an io.Reader as it is requested, instead of requiring the whole
+// payload up front the way Deserializer does. Use it to decode large
+// on-the-wire payloads directly off a net.Conn or os.File without buffering
+// the whole message first.
+//
+// CheckThatKeySlicesAreIncreasing needs to compare bytes that may no longer
+// be available from the underlying reader, so it only works over a bounded
+// lookahead window: callers that rely on canonical-map validation must opt
+// in with WithLookahead.
+type StreamDeserializer struct {
+	r        *bufio.Reader
+	offset   uint64
+	lookback []byte
+	capacity int
+	base     uint64
+	depth    int
+}
+
+// NewStreamDeserializer returns a StreamDeserializer reading from r.
+func NewStreamDeserializer(r io.Reader) *StreamDeserializer {
+	return &StreamDeserializer{r: bufio.NewReader(r)}
+}
+
+// WithLookahead enables CheckThatKeySlicesAreIncreasing by retaining the last
+// n bytes read in a sliding window. Skip it if the caller never validates
+// map/set ordering on the read side.
+func (d *StreamDeserializer) WithLookahead(n int) *StreamDeserializer {
+	d.lookback = make([]byte, 0, n)
+	d.capacity = n
+	d.base = d.offset
+	return d
+}
+
+func (d *StreamDeserializer) remember(p []byte) {
+	if d.capacity == 0 {
+		return
+	}
+	d.lookback = append(d.lookback, p...)
+	if over := len(d.lookback) - d.capacity; over > 0 {
+		d.lookback = d.lookback[over:]
+		d.base += uint64(over)
+	}
+}
+
+func (d *StreamDeserializer) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.offset++
+	d.remember([]byte{b})
+	return b, nil
+}
+
+func (d *StreamDeserializer) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	d.offset += uint64(n)
+	d.remember(b)
+	return b, nil
+}
+
+// GetBufferOffset returns the number of bytes consumed from the reader so
+// far.
+func (d *StreamDeserializer) GetBufferOffset() uint64 {
+	return d.offset
+}
+
+func (d *StreamDeserializer) DeserializeU8() (uint8, error) {
+	return d.readByte()
+}
+
+func (d *StreamDeserializer) DeserializeU16() (uint16, error) {
+	b, err := d.read(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (d *StreamDeserializer) DeserializeU32() (uint32, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (d *StreamDeserializer) DeserializeU64() (uint64, error) {
+	b, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (d *StreamDeserializer) DeserializeU128() (serde.Uint128, error) {
+	low, err := d.DeserializeU64()
+	if err != nil {
+		return serde.Uint128{}, err
+	}
+	high, err := d.DeserializeU64()
+	if err != nil {
+		return serde.Uint128{}, err
+	}
+	return serde.Uint128{High: high, Low: low}, nil
+}
+
+func (d *StreamDeserializer) DeserializeI8() (int8, error) {
+	x, err := d.DeserializeU8()
+	return int8(x), err
+}
+
+func (d *StreamDeserializer) DeserializeI16() (int16, error) {
+	x, err := d.DeserializeU16()
+	return int16(x), err
+}
+
+func (d *StreamDeserializer) DeserializeI32() (int32, error) {
+	x, err := d.DeserializeU32()
+	return int32(x), err
+}
+
+func (d *StreamDeserializer) DeserializeI64() (int64, error) {
+	x, err := d.DeserializeU64()
+	return int64(x), err
+}
+
+func (d *StreamDeserializer) DeserializeI128() (serde.Int128, error) {
+	low, err := d.DeserializeU64()
+	if err != nil {
+		return serde.Int128{}, err
+	}
+	high, err := d.DeserializeI64()
+	if err != nil {
+		return serde.Int128{}, err
+	}
+	return serde.Int128{High: high, Low: low}, nil
+}
+
+func (d *StreamDeserializer) DeserializeBool() (bool, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return false, err
+	}
+	switch b {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid bool byte: expected 0 / 1, but got %d", b)
+	}
+}
+
+func (d *StreamDeserializer) DeserializeUnit() (struct{}, error) {
+	return struct{}{}, nil
+}
+
+// DeserializeChar is unimplemented: see StreamSerializer.SerializeChar.
+func (d *StreamDeserializer) DeserializeChar() (rune, error) {
+	return 0, errors.New("bcs: the `char` type is not supported")
+}
+
+// DeserializeF32 is unimplemented: see StreamSerializer.SerializeF32.
+func (d *StreamDeserializer) DeserializeF32() (float32, error) {
+	return 0, errors.New("bcs: the `f32` type is not supported")
+}
+
+// DeserializeF64 is unimplemented: see StreamSerializer.SerializeF32.
+func (d *StreamDeserializer) DeserializeF64() (float64, error) {
+	return 0, errors.New("bcs: the `f64` type is not supported")
+}
+
+func (d *StreamDeserializer) DeserializeBytes() ([]byte, error) {
+	n, err := d.DeserializeLen()
+	if err != nil {
+		return nil, err
+	}
+	return d.read(int(n))
+}
+
+func (d *StreamDeserializer) DeserializeStr() (string, error) {
+	b, err := d.DeserializeBytes()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("invalid UTF8 string")
+	}
+	return string(b), nil
+}
+
+func (d *StreamDeserializer) DeserializeLen() (uint64, error) {
+	value, err := readULEB128AsU32(d.readByte)
+	if err != nil {
+		return 0, err
+	}
+	if value > MaxSequenceLength {
+		return 0, errors.New("length is too large")
+	}
+	return uint64(value), nil
+}
+
+func (d *StreamDeserializer) DeserializeVariantIndex() (uint32, error) {
+	return readULEB128AsU32(d.readByte)
+}
+
+func (d *StreamDeserializer) DeserializeOptionTag() (bool, error) {
+	return d.DeserializeBool()
+}
+
+// CheckThatKeySlicesAreIncreasing compares two previously-read byte ranges,
+// as Deserializer.CheckThatKeySlicesAreIncreasing does. It can only see
+// whatever is still inside the lookahead window set up by WithLookahead;
+// ranges that have scrolled out of it are reported as an error rather than
+// silently compared against the wrong bytes.
+func (d *StreamDeserializer) CheckThatKeySlicesAreIncreasing(slice1, slice2 serde.Slice) error {
+	if d.capacity == 0 {
+		return errors.New("bcs: CheckThatKeySlicesAreIncreasing requires WithLookahead")
+	}
+	a, err := d.window(slice1)
+	if err != nil {
+		return err
+	}
+	b, err := d.window(slice2)
+	if err != nil {
+		return err
+	}
+	if bytes.Compare(a, b) >= 0 {
+		return errors.New("keys were not serialized in the expected order")
+	}
+	return nil
+}
+
+func (d *StreamDeserializer) window(s serde.Slice) ([]byte, error) {
+	if s.Start >= s.End || s.Start < d.base || s.End > d.base+uint64(len(d.lookback)) {
+		return nil, fmt.Errorf("bcs: slice [%d:%d) has scrolled out of the %d-byte lookahead window", s.Start, s.End, d.capacity)
+	}
+	return d.lookback[s.Start-d.base : s.End-d.base], nil
+}
+
+// IncreaseContainerDepth bounds recursive deserialization the same way
+// Deserializer does, rejecting deeply nested or self-referential input
+// instead of recursing until the stack overflows.
+func (d *StreamDeserializer) IncreaseContainerDepth() error {
+	if d.depth >= MaxContainerDepth {
+		return errors.New("bcs: exceeded maximum container depth")
+	}
+	d.depth++
+	return nil
+}
+
+// DecreaseContainerDepth undoes one IncreaseContainerDepth call.
+func (d *StreamDeserializer) DecreaseContainerDepth() {
+	d.depth--
+}
+
+// appendULEB128 encodes value using the same variable-length, 7-bits-per-byte
+// scheme Serializer uses for lengths and variant indices, without depending
+// on that type's internal helper.
+func appendULEB128(value uint32) []byte {
+	var out []byte
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if value != 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if value == 0 {
+			return out
+		}
+	}
+}
+
+// readULEB128AsU32 decodes a ULEB128-encoded uint32 one byte at a time,
+// using readByte as the byte source, so StreamDeserializer can share the
+// same overflow rules as Deserializer without requiring a full buffer.
+func readULEB128AsU32(readByte func() (byte, error)) (uint32, error) {
+	var value uint64
+	for shift := uint(0); shift < 32; shift += 7 {
+		b, err := readByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if value > uint64(^uint32(0)) {
+				return 0, errors.New("overflow while parsing uleb128-encoded uint32 value")
+			}
+			return uint32(value), nil
+		}
+	}
+	return 0, errors.New("overflow while parsing uleb128-encoded uint32 value")
+}
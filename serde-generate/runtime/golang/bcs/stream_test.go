@@ -0,0 +1,138 @@
+// Copyright (c) Facebook, Inc. and its affiliates
+// SPDX-License-Identifier: MIT OR Apache-2.0
+
+package bcs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/bcs"
+	"github.com/zefchain/serde-reflection/serde-generate/runtime/golang/serde"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamSerializeDeserializeRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	s := bcs.NewStreamSerializer(&buf)
+
+	require.NoError(t, s.SerializeStr("hello"))
+	require.NoError(t, s.SerializeU32(42))
+	require.NoError(t, s.SerializeBool(true))
+
+	expected := bcs.NewSerializer()
+	require.NoError(t, expected.SerializeStr("hello"))
+	require.NoError(t, expected.SerializeU32(42))
+	require.NoError(t, expected.SerializeBool(true))
+	assert.Equal(t, expected.GetBytes(), buf.Bytes())
+
+	d := bcs.NewStreamDeserializer(&buf)
+	str, err := d.DeserializeStr()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", str)
+	n, err := d.DeserializeU32()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), n)
+	b, err := d.DeserializeBool()
+	require.NoError(t, err)
+	assert.True(t, b)
+}
+
+func TestStreamSerializerWithoutBufferingHasNoBytes(t *testing.T) {
+	var buf bytes.Buffer
+	s := bcs.NewStreamSerializer(&buf)
+	require.NoError(t, s.SerializeU8(7))
+	assert.Nil(t, s.GetBytes())
+	s.SortMapEntries([]uint64{0, 1}) // no-op without WithBuffering
+	assert.Equal(t, []byte{7}, buf.Bytes())
+}
+
+func TestStreamSerializerWithBufferingSortMapEntriesAndFlush(t *testing.T) {
+	var buf bytes.Buffer
+	s := bcs.NewStreamSerializer(&buf).WithBuffering()
+
+	require.NoError(t, s.SerializeU8(255))
+	offsetA := s.GetBufferOffset()
+	require.NoError(t, s.SerializeU32(2))
+	offsetB := s.GetBufferOffset()
+	require.NoError(t, s.SerializeU32(1))
+	offsetEnd := s.GetBufferOffset()
+
+	s.SortMapEntries([]uint64{offsetA, offsetB, offsetEnd})
+	assert.Equal(t, []byte{255, 1, 0, 0, 0, 2, 0, 0, 0}, s.GetBytes())
+
+	assert.Equal(t, 0, buf.Len(), "Flush has not been called yet")
+	require.NoError(t, s.Flush())
+	assert.Equal(t, []byte{255, 1, 0, 0, 0, 2, 0, 0, 0}, buf.Bytes())
+	assert.Nil(t, s.GetBytes(), "Flush resets the buffer")
+}
+
+func TestStreamSerializeDeserializeUnsupportedTypes(t *testing.T) {
+	var buf bytes.Buffer
+	s := bcs.NewStreamSerializer(&buf)
+	require.Error(t, s.SerializeChar('x'))
+	require.Error(t, s.SerializeF32(1.5))
+	require.Error(t, s.SerializeF64(1.5))
+
+	d := bcs.NewStreamDeserializer(&buf)
+	_, err := d.DeserializeChar()
+	require.Error(t, err)
+	_, err = d.DeserializeF32()
+	require.Error(t, err)
+	_, err = d.DeserializeF64()
+	require.Error(t, err)
+}
+
+func TestStreamDeserializerCheckThatKeySlicesAreIncreasingRequiresLookahead(t *testing.T) {
+	d := bcs.NewStreamDeserializer(bytes.NewReader([]byte{0, 1, 2, 0, 2}))
+	_, err := d.DeserializeU32()
+	require.NoError(t, err)
+	err = d.CheckThatKeySlicesAreIncreasing(serde.Slice{0, 3}, serde.Slice{3, 5})
+	require.Error(t, err, "WithLookahead was never called")
+}
+
+func TestStreamDeserializerCheckThatKeySlicesAreIncreasingWithLookahead(t *testing.T) {
+	d := bcs.NewStreamDeserializer(bytes.NewReader([]byte{0, 1, 2, 0, 2})).WithLookahead(16)
+	_, err := d.DeserializeU32()
+	require.NoError(t, err)
+	_, err = d.DeserializeU8() // both slices must have been read already
+	require.NoError(t, err)
+	require.NoError(t, d.CheckThatKeySlicesAreIncreasing(serde.Slice{0, 3}, serde.Slice{3, 5}))
+	require.Error(t, d.CheckThatKeySlicesAreIncreasing(serde.Slice{0, 3}, serde.Slice{0, 3}))
+}
+
+func TestStreamDeserializerCheckThatKeySlicesAreIncreasingOutsideWindow(t *testing.T) {
+	d := bcs.NewStreamDeserializer(bytes.NewReader([]byte{0, 1, 2, 0, 2})).WithLookahead(1)
+	_, err := d.DeserializeU32()
+	require.NoError(t, err)
+	err = d.CheckThatKeySlicesAreIncreasing(serde.Slice{0, 3}, serde.Slice{3, 5})
+	require.Error(t, err, "the first slice has scrolled out of a 1-byte lookahead window")
+}
+
+// IncreaseContainerDepth succeeds MaxContainerDepth times in a row and the
+// next call fails, whatever the reader still holds.
+func TestStreamDeserializerIncreaseContainerDepthBound(t *testing.T) {
+	d := bcs.NewStreamDeserializer(bytes.NewReader(bytes.Repeat([]byte{1}, 2_000_000)))
+	var err error
+	for i := 0; i < bcs.MaxContainerDepth+1; i++ {
+		err = d.IncreaseContainerDepth()
+		if err != nil {
+			break
+		}
+	}
+	require.Error(t, err)
+}
+
+func TestStreamSerializerIncreaseContainerDepthBound(t *testing.T) {
+	var buf bytes.Buffer
+	s := bcs.NewStreamSerializer(&buf)
+	var err error
+	for i := 0; i < bcs.MaxContainerDepth+1; i++ {
+		err = s.IncreaseContainerDepth()
+		if err != nil {
+			break
+		}
+	}
+	require.Error(t, err)
+}